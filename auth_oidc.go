@@ -0,0 +1,165 @@
+package pggateway
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/c653labs/pggateway/metrics"
+	"github.com/c653labs/pgproto"
+)
+
+// OidcAuthenticator validates a bearer token, sent by the client as the
+// startup password, against a configured OIDC provider's JWKS endpoint.
+// It's registered under ListenerConfig.Authentication as "oidc".
+type OidcAuthenticator struct {
+	jwksURL  string
+	claim    string
+	jwks     *jwksCache
+	cacheTTL time.Duration
+}
+
+// NewOidcAuthenticator builds the "oidc" backend from its ConfigMap.
+// Supported keys: jwks_url, username_claim (default "preferred_username"),
+// cache_ttl_seconds (default 300).
+func NewOidcAuthenticator(cfg ConfigMap) (*OidcAuthenticator, error) {
+	jwksURL, ok := cfg.String("jwks_url")
+	if !ok {
+		return nil, fmt.Errorf("oidc: missing jwks_url")
+	}
+
+	ttl := time.Duration(cfg.IntDefault("cache_ttl_seconds", 300)) * time.Second
+
+	return &OidcAuthenticator{
+		jwksURL:  jwksURL,
+		claim:    cfg.StringDefault("username_claim", "preferred_username"),
+		jwks:     newJWKSCache(jwksURL, ttl),
+		cacheTTL: ttl,
+	}, nil
+}
+
+func (a *OidcAuthenticator) Name() string {
+	return "oidc"
+}
+
+// Authenticate reads the client's bearer token from the password field of
+// the startup challenge, validates its signature against the JWKS-cached
+// public key for its `kid`, and maps the configured claim onto Session.User.
+func (a *OidcAuthenticator) Authenticate(s *Session) (bool, error) {
+	_, pwdMsg, err := s.GetUserPassword(pgproto.AuthenticationMethodCleartext)
+	if err != nil {
+		return false, err
+	}
+
+	token, err := jwt.Parse(string(pwdMsg.Password), func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.jwks.Key(kid)
+	})
+	if err != nil || !token.Valid {
+		metrics.AuthFailures.WithLabelValues(a.Name()).Inc()
+		return false, nil
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false, fmt.Errorf("oidc: unexpected claims type")
+	}
+
+	username, ok := claims[a.claim].(string)
+	if !ok || username == "" {
+		return false, fmt.Errorf("oidc: claim %q missing from token", a.claim)
+	}
+	s.User = []byte(username)
+
+	return true, nil
+}
+
+// jwksCache fetches and caches a provider's JSON Web Key Set, re-fetching
+// once the cache expires or an unknown `kid` is requested (to pick up key
+// rotation without waiting out the full TTL).
+type jwksCache struct {
+	url string
+	ttl time.Duration
+
+	mu       sync.Mutex
+	fetched  time.Time
+	keysByID map[string]*rsa.PublicKey
+}
+
+func newJWKSCache(url string, ttl time.Duration) *jwksCache {
+	return &jwksCache{url: url, ttl: ttl, keysByID: map[string]*rsa.PublicKey{}}
+}
+
+func (c *jwksCache) Key(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keysByID[kid]; ok && time.Since(c.fetched) < c.ttl {
+		return key, nil
+	}
+
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+
+	key, ok := c.keysByID[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+type jwksDocument struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func (c *jwksCache) refresh() error {
+	resp, err := http.Get(c.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var doc jwksDocument
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	c.keysByID = keys
+	c.fetched = time.Now()
+	return nil
+}
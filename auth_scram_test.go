@@ -0,0 +1,94 @@
+package pggateway
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestXorBytes(t *testing.T) {
+	cases := []struct {
+		name string
+		a    []byte
+		b    []byte
+		want []byte
+	}{
+		{"all zero", []byte{0, 0, 0}, []byte{0, 0, 0}, []byte{0, 0, 0}},
+		{"identity", []byte{0xFF, 0x0F, 0x01}, []byte{0xFF, 0x0F, 0x01}, []byte{0, 0, 0}},
+		{"mixed", []byte{0b1010, 0b0011}, []byte{0b0110, 0b0101}, []byte{0b1100, 0b0110}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := xorBytes(c.a, c.b)
+			if !bytes.Equal(got, c.want) {
+				t.Errorf("xorBytes(%v, %v) = %v, want %v", c.a, c.b, got, c.want)
+			}
+		})
+	}
+}
+
+func TestClientFirstBare(t *testing.T) {
+	parsed, err := clientFirstBare([]byte("n,,n=user,r=abcd1234"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.bare != "n=user,r=abcd1234" {
+		t.Errorf("bare = %q, want %q", parsed.bare, "n=user,r=abcd1234")
+	}
+	if parsed.nonce != "abcd1234" {
+		t.Errorf("nonce = %q, want %q", parsed.nonce, "abcd1234")
+	}
+}
+
+func TestClientFirstBareErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+	}{
+		{"missing gs2 header", "n=user,r=abcd1234"},
+		{"missing nonce", "n,,n=user"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := clientFirstBare([]byte(c.data)); err == nil {
+				t.Errorf("clientFirstBare(%q) = nil error, want error", c.data)
+			}
+		})
+	}
+}
+
+func TestParseClientFinal(t *testing.T) {
+	// p= is base64 for the byte sequence [0xAB, 0xCD].
+	parsed, err := parseClientFinal([]byte("c=biws,r=abcd1234,p=q80="))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.withoutProof != "c=biws,r=abcd1234" {
+		t.Errorf("withoutProof = %q, want %q", parsed.withoutProof, "c=biws,r=abcd1234")
+	}
+	if parsed.nonce != "abcd1234" {
+		t.Errorf("nonce = %q, want %q", parsed.nonce, "abcd1234")
+	}
+	if !bytes.Equal(parsed.proof, []byte{0xAB, 0xCD}) {
+		t.Errorf("proof = %v, want %v", parsed.proof, []byte{0xAB, 0xCD})
+	}
+}
+
+func TestParseClientFinalErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+	}{
+		{"missing proof", "c=biws,r=abcd1234"},
+		{"invalid base64 proof", "c=biws,r=abcd1234,p=not-base64!!"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := parseClientFinal([]byte(c.data)); err == nil {
+				t.Errorf("parseClientFinal(%q) = nil error, want error", c.data)
+			}
+		})
+	}
+}
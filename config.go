@@ -1,6 +1,7 @@
 package pggateway
 
 import (
+	"github.com/c653labs/pggateway/metrics"
 	"github.com/go-yaml/yaml"
 )
 
@@ -8,14 +9,32 @@ type Config struct {
 	Procs     int                        `yaml:"procs,omitempty"`
 	Logging   map[string]ConfigMap       `yaml:"logging,omitempty"`
 	Listeners map[string]*ListenerConfig `yaml:"listeners,omitempty"`
+	Metrics   MetricsConfig              `yaml:"metrics,omitempty"`
+}
+
+// MetricsConfig configures the optional Prometheus /metrics endpoint.
+type MetricsConfig struct {
+	Bind string `yaml:"bind,omitempty"`
 }
 
 type TargetConfig struct {
 	Host    string `yaml:"host,omitempty"`
 	Port    int    `yaml:"port,omitempty"`
 	SSLMode string `yaml:"sslmode,omitempty"`
+
+	PoolMode         string `yaml:"pool_mode,omitempty"`
+	MaxConns         int    `yaml:"max_conns,omitempty"`
+	MinIdle          int    `yaml:"min_idle,omitempty"`
+	MaxLifetime      int    `yaml:"max_lifetime,omitempty"`
+	ServerResetQuery string `yaml:"server_reset_query,omitempty"`
 }
 
+const (
+	PoolModeSession     = "session"
+	PoolModeTransaction = "transaction"
+	PoolModeStatement   = "statement"
+)
+
 type SSLConfig struct {
 	Enabled     bool   `yaml:"enabled,omitempty"`
 	Required    bool   `yaml:"required,omitempty"`
@@ -67,6 +86,27 @@ func (c ConfigMap) BoolDefault(name string, d bool) bool {
 	return b
 }
 
+func (c ConfigMap) Int(name string) (int, bool) {
+	v, ok := c[name]
+	if !ok {
+		return 0, false
+	}
+
+	i, ok := v.(int)
+	if !ok {
+		return 0, false
+	}
+	return i, true
+}
+
+func (c ConfigMap) IntDefault(name string, d int) int {
+	i, ok := c.Int(name)
+	if !ok {
+		return d
+	}
+	return i
+}
+
 func (c ConfigMap) Map(name string) (ConfigMap, bool) {
 	raw, ok := c[name]
 	if !ok {
@@ -91,10 +131,37 @@ func (c ConfigMap) Map(name string) (ConfigMap, bool) {
 type ListenerConfig struct {
 	Bind           string               `yaml:"bind,omitempty"`
 	SSL            SSLConfig            `yaml:"ssl,omitempty"`
-	Target         TargetConfig         `yaml:"target,omitempty"`
+	Targets        []TargetRoleConfig   `yaml:"targets,omitempty"`
+	Routing        RoutingConfig        `yaml:"routing,omitempty"`
 	Authentication map[string]ConfigMap `yaml:"authentication,omitempty"`
 	Logging        map[string]ConfigMap `yaml:"logging,omitempty"`
 	Databases      map[string]ConfigMap `yaml:"databases,omitempty"`
+	Interceptors   map[string]ConfigMap `yaml:"interceptors,omitempty"`
+	DrainTimeout   int                  `yaml:"drain_timeout,omitempty"`
+}
+
+// TargetRole is the replication role pggateway assumes a target plays,
+// used by the QueryRouter to decide which targets may serve a read-only
+// transaction.
+type TargetRole string
+
+const (
+	TargetRolePrimary TargetRole = "primary"
+	TargetRoleReplica TargetRole = "replica"
+)
+
+// TargetRoleConfig is a single entry of ListenerConfig.Targets: a target
+// Postgres server plus the role it plays in the cluster.
+type TargetRoleConfig struct {
+	TargetConfig `yaml:",inline"`
+	Role         TargetRole `yaml:"role,omitempty"`
+}
+
+// RoutingConfig configures QueryRouter's read/write split across a
+// listener's Targets.
+type RoutingConfig struct {
+	MaxReplicaLagBytes  int64 `yaml:"max_replica_lag_bytes,omitempty"`
+	HealthCheckInterval int   `yaml:"health_check_interval_seconds,omitempty"`
 }
 
 func NewConfig() *Config {
@@ -128,3 +195,17 @@ func (c *Config) GetListeners() []*Listener {
 	}
 	return listeners
 }
+
+// ServeMetrics starts the Prometheus /metrics endpoint in the background
+// if Metrics.Bind is configured. It is a no-op otherwise.
+func (c *Config) ServeMetrics() {
+	if c.Metrics.Bind == "" {
+		return
+	}
+
+	go func() {
+		if err := metrics.Serve(c.Metrics.Bind); err != nil {
+			panic(err)
+		}
+	}()
+}
@@ -0,0 +1,191 @@
+package pggateway
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ConfigWatcher re-parses a YAML config file on SIGHUP or a filesystem
+// change and applies the difference to a running set of Listeners without
+// dropping in-flight sessions: unchanged listeners keep their sockets,
+// removed listeners drain before stopping, new listeners bind fresh
+// sockets, and mutated auth/logging plugins are swapped in place.
+type ConfigWatcher struct {
+	path    string
+	plugins *PluginRegistry
+
+	mu        sync.RWMutex
+	config    *Config
+	listeners map[string]*Listener
+
+	watcher *fsnotify.Watcher
+	sighup  chan os.Signal
+	stop    chan struct{}
+}
+
+// NewConfigWatcher builds a watcher for the config file at path, taking
+// ownership of the already-running listeners (as returned by
+// Config.GetListeners) so it can diff future reloads against them.
+func NewConfigWatcher(path string, config *Config, listeners []*Listener, plugins *PluginRegistry) *ConfigWatcher {
+	byBind := make(map[string]*Listener, len(listeners))
+	for _, l := range listeners {
+		byBind[l.Config.Bind] = l
+	}
+
+	return &ConfigWatcher{
+		path:      path,
+		plugins:   plugins,
+		config:    config,
+		listeners: byBind,
+		sighup:    make(chan os.Signal, 1),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start begins watching for SIGHUP and filesystem changes to the config
+// file, applying validated reloads as they arrive. It returns once the
+// watcher is set up; reloads happen in a background goroutine until Stop
+// is called.
+func (w *ConfigWatcher) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(w.path); err != nil {
+		watcher.Close()
+		return err
+	}
+	w.watcher = watcher
+
+	signal.Notify(w.sighup, syscall.SIGHUP)
+
+	go w.run()
+	return nil
+}
+
+// Stop stops watching for reloads. It does not drain or close any
+// listeners.
+func (w *ConfigWatcher) Stop() {
+	close(w.stop)
+	signal.Stop(w.sighup)
+	if w.watcher != nil {
+		w.watcher.Close()
+	}
+}
+
+func (w *ConfigWatcher) run() {
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.sighup:
+			w.reload()
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				w.reload()
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.plugins.LogError(LoggingContext{"path": w.path}, "config watcher error: %s", err)
+		}
+	}
+}
+
+// reload re-reads and validates the config file, rejecting it (and keeping
+// the currently running config) if it fails to parse or any plugin rejects
+// its new ConfigMap, and otherwise applies the diff.
+func (w *ConfigWatcher) reload() {
+	raw, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		w.plugins.LogError(LoggingContext{"path": w.path}, "failed to read config for reload: %s", err)
+		return
+	}
+
+	next := NewConfig()
+	if err := next.Unmarshal(raw); err != nil {
+		w.plugins.LogError(LoggingContext{"path": w.path}, "rejected invalid config reload: %s", err)
+		return
+	}
+
+	if err := w.plugins.ValidateAll(next); err != nil {
+		w.plugins.LogError(LoggingContext{"path": w.path}, "rejected config reload failing plugin validation: %s", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.applyLocked(next)
+	w.config = next
+}
+
+// applyLocked diffs next against the currently running config (w.mu held)
+// and applies the minimum set of changes: bind/stop for added/removed
+// listeners, and an atomic plugin swap for listeners whose auth/logging/
+// interceptor config changed but whose socket can stay open.
+func (w *ConfigWatcher) applyLocked(next *Config) {
+	for bind, nextListener := range next.Listeners {
+		current, exists := w.listeners[bind]
+		if !exists {
+			listener := NewListener(nextListener)
+			w.listeners[bind] = listener
+			go listener.Listen()
+			continue
+		}
+
+		if listenerSocketChanged(current.Config, nextListener) {
+			w.drainAndReplace(bind, current, nextListener)
+			continue
+		}
+
+		if listenerPluginsChanged(current.Config, nextListener) {
+			w.plugins.Swap(bind, nextListener)
+			current.Config = nextListener
+		}
+	}
+
+	for bind, listener := range w.listeners {
+		if _, exists := next.Listeners[bind]; !exists {
+			w.drain(bind, listener)
+		}
+	}
+}
+
+func (w *ConfigWatcher) drainAndReplace(bind string, current *Listener, next *ListenerConfig) {
+	w.drain(bind, current)
+
+	listener := NewListener(next)
+	w.listeners[bind] = listener
+	go listener.Listen()
+}
+
+func (w *ConfigWatcher) drain(bind string, listener *Listener) {
+	timeout := time.Duration(listener.Config.DrainTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	delete(w.listeners, bind)
+	go listener.Drain(timeout)
+}
+
+func listenerSocketChanged(current, next *ListenerConfig) bool {
+	return current.Bind != next.Bind || !reflect.DeepEqual(current.SSL, next.SSL) || !reflect.DeepEqual(current.Targets, next.Targets)
+}
+
+func listenerPluginsChanged(current, next *ListenerConfig) bool {
+	return !reflect.DeepEqual(current.Authentication, next.Authentication) ||
+		!reflect.DeepEqual(current.Logging, next.Logging) ||
+		!reflect.DeepEqual(current.Interceptors, next.Interceptors)
+}
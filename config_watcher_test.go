@@ -0,0 +1,59 @@
+package pggateway
+
+import "testing"
+
+func TestListenerSocketChanged(t *testing.T) {
+	base := &ListenerConfig{
+		Bind:    "0.0.0.0:5432",
+		SSL:     SSLConfig{Enabled: true},
+		Targets: []TargetRoleConfig{{Role: TargetRolePrimary}},
+	}
+
+	cases := []struct {
+		name string
+		next *ListenerConfig
+		want bool
+	}{
+		{name: "identical config", next: &ListenerConfig{Bind: base.Bind, SSL: base.SSL, Targets: base.Targets}, want: false},
+		{name: "bind changed", next: &ListenerConfig{Bind: "0.0.0.0:5433", SSL: base.SSL, Targets: base.Targets}, want: true},
+		{name: "ssl changed", next: &ListenerConfig{Bind: base.Bind, SSL: SSLConfig{Enabled: false}, Targets: base.Targets}, want: true},
+		{name: "targets changed", next: &ListenerConfig{Bind: base.Bind, SSL: base.SSL, Targets: []TargetRoleConfig{{Role: TargetRoleReplica}}}, want: true},
+		{name: "unrelated field changed", next: &ListenerConfig{Bind: base.Bind, SSL: base.SSL, Targets: base.Targets, DrainTimeout: 60}, want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := listenerSocketChanged(base, tc.next); got != tc.want {
+				t.Errorf("listenerSocketChanged() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListenerPluginsChanged(t *testing.T) {
+	base := &ListenerConfig{
+		Authentication: map[string]ConfigMap{"scram": {"enabled": true}},
+		Logging:        map[string]ConfigMap{"zap": {"level": "info"}},
+		Interceptors:   map[string]ConfigMap{"audit": {}},
+	}
+
+	cases := []struct {
+		name string
+		next *ListenerConfig
+		want bool
+	}{
+		{name: "identical config", next: &ListenerConfig{Authentication: base.Authentication, Logging: base.Logging, Interceptors: base.Interceptors}, want: false},
+		{name: "authentication changed", next: &ListenerConfig{Authentication: map[string]ConfigMap{"ldap": {}}, Logging: base.Logging, Interceptors: base.Interceptors}, want: true},
+		{name: "logging changed", next: &ListenerConfig{Authentication: base.Authentication, Logging: map[string]ConfigMap{"zap": {"level": "debug"}}, Interceptors: base.Interceptors}, want: true},
+		{name: "interceptors changed", next: &ListenerConfig{Authentication: base.Authentication, Logging: base.Logging, Interceptors: map[string]ConfigMap{}}, want: true},
+		{name: "bind changed but no plugin change", next: &ListenerConfig{Bind: "0.0.0.0:1", Authentication: base.Authentication, Logging: base.Logging, Interceptors: base.Interceptors}, want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := listenerPluginsChanged(base, tc.next); got != tc.want {
+				t.Errorf("listenerPluginsChanged() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package pggateway
+
+import (
+	"github.com/c653labs/pgproto"
+)
+
+// QueryInterceptor lets a plugin observe and rewrite messages that flow
+// through the extended query protocol (and the simple Query message) for a
+// single session. Hooks are called in protocol order; returning a non-nil
+// *InterceptResult from any hook short-circuits the rest of the pipeline for
+// that message.
+type QueryInterceptor interface {
+	Name() string
+
+	OnParse(s *Session, stmt *PreparedStatement, msg *pgproto.Parse) (*InterceptResult, error)
+	OnBind(s *Session, portal *Portal, msg *pgproto.Bind) (*InterceptResult, error)
+	OnQuery(s *Session, msg *pgproto.Query) (*InterceptResult, error)
+	OnRowDescription(s *Session, msg *pgproto.RowDescription) (*InterceptResult, error)
+	OnDataRow(s *Session, msg *pgproto.DataRow) (*InterceptResult, error)
+	OnCommandComplete(s *Session, msg *pgproto.CommandComplete) (*InterceptResult, error)
+}
+
+// InterceptResult is returned by a QueryInterceptor hook to control how the
+// mediation pipeline proceeds for the message that was just inspected.
+type InterceptResult struct {
+	// Drop silently discards the message instead of forwarding it.
+	Drop bool
+	// Forward replaces the message that will be forwarded to the original
+	// destination (client or server, depending on the hook).
+	Forward pgproto.Message
+	// Synthetic, if set, is written directly to the client. It does not stop
+	// the message itself from being forwarded; combine it with Drop to
+	// short-circuit the round trip. Only the client-message hooks (OnParse,
+	// OnBind, OnQuery) honour it; it is ignored for the server-message hooks.
+	Synthetic []pgproto.ServerMessage
+}
+
+// PreparedStatement tracks the state of a named (or unnamed, name == "")
+// prepared statement for the lifetime of a session, so that a rewrite
+// applied to a Parse message is consistently honoured by later Bind,
+// Describe and Execute messages that reference it by name.
+type PreparedStatement struct {
+	Name       string
+	SQL        []byte
+	ParamOIDs  []int32
+	ResultCols []pgproto.FieldDescription
+}
+
+// Portal tracks a bound portal, linking it back to the prepared statement it
+// was bound from and the result column formats negotiated at Bind time.
+type Portal struct {
+	Name          string
+	Statement     *PreparedStatement
+	ParamFormats  []int16
+	ResultFormats []int16
+}
+
+func newInterceptorState() *interceptorState {
+	return &interceptorState{
+		statements: map[string]*PreparedStatement{},
+		portals:    map[string]*Portal{},
+	}
+}
+
+// interceptorState holds the per-session prepared statement and portal
+// tables used to resolve Bind/Describe/Execute messages back to the Parse
+// (and any rewrite applied to it) that created them.
+type interceptorState struct {
+	statements map[string]*PreparedStatement
+	portals    map[string]*Portal
+}
+
+func (st *interceptorState) storeStatement(stmt *PreparedStatement) {
+	st.statements[stmt.Name] = stmt
+}
+
+func (st *interceptorState) storePortal(p *Portal) {
+	st.portals[p.Name] = p
+}
+
+func (st *interceptorState) closeStatement(name string) {
+	delete(st.statements, name)
+}
+
+func (st *interceptorState) closePortal(name string) {
+	delete(st.portals, name)
+}
+
+// runInterceptors calls fn for each registered interceptor in order,
+// returning the first non-nil result (and stopping the chain there) or the
+// first error encountered.
+func runInterceptors(interceptors []QueryInterceptor, fn func(QueryInterceptor) (*InterceptResult, error)) (*InterceptResult, error) {
+	for _, i := range interceptors {
+		result, err := fn(i)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			return result, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,92 @@
+package pggateway
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c653labs/pgproto"
+)
+
+// stubInterceptor lets each test control exactly what a single hook
+// returns, without pulling in the full QueryInterceptor surface.
+type stubInterceptor struct {
+	name   string
+	result *InterceptResult
+	err    error
+	called *int
+}
+
+func (s *stubInterceptor) Name() string { return s.name }
+func (s *stubInterceptor) call() (*InterceptResult, error) {
+	if s.called != nil {
+		*s.called++
+	}
+	return s.result, s.err
+}
+func (s *stubInterceptor) OnParse(*Session, *PreparedStatement, *pgproto.Parse) (*InterceptResult, error) {
+	return s.call()
+}
+func (s *stubInterceptor) OnBind(*Session, *Portal, *pgproto.Bind) (*InterceptResult, error) {
+	return s.call()
+}
+func (s *stubInterceptor) OnQuery(*Session, *pgproto.Query) (*InterceptResult, error) {
+	return s.call()
+}
+func (s *stubInterceptor) OnRowDescription(*Session, *pgproto.RowDescription) (*InterceptResult, error) {
+	return s.call()
+}
+func (s *stubInterceptor) OnDataRow(*Session, *pgproto.DataRow) (*InterceptResult, error) {
+	return s.call()
+}
+func (s *stubInterceptor) OnCommandComplete(*Session, *pgproto.CommandComplete) (*InterceptResult, error) {
+	return s.call()
+}
+
+func TestRunInterceptorsStopsAtFirstResult(t *testing.T) {
+	firstCalls, secondCalls, thirdCalls := 0, 0, 0
+	first := &stubInterceptor{name: "first", called: &firstCalls}
+	second := &stubInterceptor{name: "second", result: &InterceptResult{Drop: true}, called: &secondCalls}
+	third := &stubInterceptor{name: "third", called: &thirdCalls}
+
+	result, err := runInterceptors([]QueryInterceptor{first, second, third}, func(i QueryInterceptor) (*InterceptResult, error) {
+		return i.(*stubInterceptor).call()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result == nil || !result.Drop {
+		t.Fatalf("result = %v, want the Drop result from the second interceptor", result)
+	}
+	if firstCalls != 1 || secondCalls != 1 || thirdCalls != 0 {
+		t.Errorf("calls = (%d, %d, %d), want (1, 1, 0) -- chain should stop once a result is returned", firstCalls, secondCalls, thirdCalls)
+	}
+}
+
+func TestRunInterceptorsStopsAtFirstError(t *testing.T) {
+	wantErr := errors.New("boom")
+	secondCalls := 0
+	first := &stubInterceptor{name: "first", err: wantErr}
+	second := &stubInterceptor{name: "second", called: &secondCalls}
+
+	result, err := runInterceptors([]QueryInterceptor{first, second}, func(i QueryInterceptor) (*InterceptResult, error) {
+		return i.(*stubInterceptor).call()
+	})
+	if err != wantErr {
+		t.Fatalf("err = %v, want %v", err, wantErr)
+	}
+	if result != nil {
+		t.Errorf("result = %v, want nil", result)
+	}
+	if secondCalls != 0 {
+		t.Errorf("second interceptor was called %d times, want 0 -- chain should stop on error", secondCalls)
+	}
+}
+
+func TestRunInterceptorsNoResult(t *testing.T) {
+	result, err := runInterceptors([]QueryInterceptor{&stubInterceptor{name: "noop"}}, func(i QueryInterceptor) (*InterceptResult, error) {
+		return i.(*stubInterceptor).call()
+	})
+	if err != nil || result != nil {
+		t.Errorf("runInterceptors() = (%v, %v), want (nil, nil) when no interceptor returns a result", result, err)
+	}
+}
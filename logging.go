@@ -0,0 +1,160 @@
+package pggateway
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LoggingContext carries the structured key/value pairs gathered for a
+// single log event (session identity, the pgproto message being handled,
+// timings, ...). It converts losslessly to zap.Field so the same context
+// built for free-form plugins can also drive the structured logger.
+type LoggingContext map[string]interface{}
+
+// Fields converts the context to a slice of zap.Field, preserving every
+// key/value pair via zap.Any so no information is lost in the conversion.
+func (c LoggingContext) Fields() []zap.Field {
+	fields := make([]zap.Field, 0, len(c))
+	for k, v := range c {
+		fields = append(fields, zap.Any(k, v))
+	}
+	return fields
+}
+
+// defaultRedactFields lists the pgproto field names scrubbed from logged
+// messages when a plugin config doesn't override `redact:`.
+var defaultRedactFields = []string{"password", "Password", "salt", "Salt"}
+
+// ZapLoggingPlugin is the built-in "logging.zap" plugin: a structured
+// logger backed by go.uber.org/zap, configured with the usual zap knobs
+// (level, encoding, sampling, output_paths) plus a redact list of pgproto
+// field names to scrub from `message` before it is emitted.
+type ZapLoggingPlugin struct {
+	logger *zap.Logger
+	redact map[string]bool
+}
+
+// NewZapLoggingPlugin builds the logging.zap plugin from its ConfigMap.
+// Supported keys: level, encoding ("json"|"console"), sampling.initial,
+// sampling.thereafter, output_paths, redact.
+func NewZapLoggingPlugin(cfg ConfigMap) (*ZapLoggingPlugin, error) {
+	level := zapcore.InfoLevel
+	if err := level.Set(cfg.StringDefault("level", "info")); err != nil {
+		return nil, err
+	}
+
+	encoding := cfg.StringDefault("encoding", "json")
+
+	outputPaths := []string{"stderr"}
+	if raw, ok := cfg["output_paths"]; ok {
+		paths, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("output_paths must be a list of strings")
+		}
+		outputPaths = outputPaths[:0]
+		for _, p := range paths {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("output_paths must be a list of strings")
+			}
+			outputPaths = append(outputPaths, s)
+		}
+	}
+
+	zapConfig := zap.Config{
+		Level:            zap.NewAtomicLevelAt(level),
+		Encoding:         encoding,
+		EncoderConfig:    zap.NewProductionEncoderConfig(),
+		OutputPaths:      outputPaths,
+		ErrorOutputPaths: []string{"stderr"},
+	}
+
+	if sampling, ok := cfg.Map("sampling"); ok {
+		initial := sampling.IntDefault("initial", 100)
+		thereafter := sampling.IntDefault("thereafter", 100)
+		zapConfig.Sampling = &zap.SamplingConfig{Initial: initial, Thereafter: thereafter}
+	}
+
+	logger, err := zapConfig.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	redact := map[string]bool{}
+	names := defaultRedactFields
+	if raw, ok := cfg["redact"]; ok {
+		list, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("redact must be a list of strings")
+		}
+		names = make([]string, 0, len(list))
+		for _, n := range list {
+			s, ok := n.(string)
+			if !ok {
+				return nil, fmt.Errorf("redact must be a list of strings")
+			}
+			names = append(names, s)
+		}
+	}
+	for _, n := range names {
+		redact[n] = true
+	}
+
+	return &ZapLoggingPlugin{logger: logger, redact: redact}, nil
+}
+
+func (p *ZapLoggingPlugin) Name() string {
+	return "logging.zap"
+}
+
+func (p *ZapLoggingPlugin) LogDebug(ctx LoggingContext, format string, args ...interface{}) {
+	p.logger.Debug(fmt.Sprintf(format, args...), p.redacted(ctx).Fields()...)
+}
+
+func (p *ZapLoggingPlugin) LogError(ctx LoggingContext, format string, args ...interface{}) {
+	p.logger.Error(fmt.Sprintf(format, args...), p.redacted(ctx).Fields()...)
+}
+
+// LogQuery emits a single structured event for a completed Parse or Query,
+// with enough fields to ingest into ELK/Loki without parsing free-form text.
+func (p *ZapLoggingPlugin) LogQuery(s *Session, sql []byte, duration time.Duration, rowCount int64) {
+	p.logger.Info("query",
+		zap.String("session_id", s.ID),
+		zap.String("user", string(s.User)),
+		zap.String("database", string(s.Database)),
+		zap.ByteString("sql", sql),
+		zap.Float64("duration_ms", float64(duration)/float64(time.Millisecond)),
+		zap.Int64("row_count", rowCount),
+	)
+}
+
+// redacted scrubs the redacted field names out of ctx["message"], which
+// holds the result of msg.AsMap() for the pgproto message being logged.
+func (p *ZapLoggingPlugin) redacted(ctx LoggingContext) LoggingContext {
+	if len(p.redact) == 0 {
+		return ctx
+	}
+	msg, ok := ctx["message"].(map[string]interface{})
+	if !ok {
+		return ctx
+	}
+
+	scrubbed := make(map[string]interface{}, len(msg))
+	for k, v := range msg {
+		if p.redact[k] {
+			scrubbed[k] = "[REDACTED]"
+			continue
+		}
+		scrubbed[k] = v
+	}
+
+	out := make(LoggingContext, len(ctx))
+	for k, v := range ctx {
+		out[k] = v
+	}
+	out["message"] = scrubbed
+	return out
+}
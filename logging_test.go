@@ -0,0 +1,65 @@
+package pggateway
+
+import "testing"
+
+func TestZapLoggingPluginRedacted(t *testing.T) {
+	p := &ZapLoggingPlugin{redact: map[string]bool{"password": true, "salt": true}}
+
+	ctx := LoggingContext{
+		"session_id": "abc",
+		"message": map[string]interface{}{
+			"user":     "alice",
+			"password": "hunter2",
+			"salt":     []byte{1, 2, 3},
+		},
+	}
+
+	out := p.redacted(ctx)
+
+	msg, ok := out["message"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("redacted()[\"message\"] = %v, want a map", out["message"])
+	}
+	if msg["password"] != "[REDACTED]" {
+		t.Errorf("password = %v, want [REDACTED]", msg["password"])
+	}
+	if msg["salt"] != "[REDACTED]" {
+		t.Errorf("salt = %v, want [REDACTED]", msg["salt"])
+	}
+	if msg["user"] != "alice" {
+		t.Errorf("user = %v, want untouched \"alice\"", msg["user"])
+	}
+	if out["session_id"] != "abc" {
+		t.Errorf("session_id = %v, want untouched \"abc\"", out["session_id"])
+	}
+
+	// The original context must be left untouched, since callers (LogDebug,
+	// LogError) may reuse it after logging.
+	original := ctx["message"].(map[string]interface{})
+	if original["password"] != "hunter2" {
+		t.Errorf("redacted() mutated the original context's message map")
+	}
+}
+
+func TestZapLoggingPluginRedactedNoRedactList(t *testing.T) {
+	p := &ZapLoggingPlugin{redact: map[string]bool{}}
+	ctx := LoggingContext{"message": map[string]interface{}{"password": "hunter2"}}
+
+	out := p.redacted(ctx)
+
+	msg := out["message"].(map[string]interface{})
+	if msg["password"] != "hunter2" {
+		t.Errorf("redacted() with an empty redact list scrubbed %v, want it left untouched", msg["password"])
+	}
+}
+
+func TestZapLoggingPluginRedactedNonMapMessage(t *testing.T) {
+	p := &ZapLoggingPlugin{redact: map[string]bool{"password": true}}
+	ctx := LoggingContext{"message": "not a map"}
+
+	out := p.redacted(ctx)
+
+	if out["message"] != "not a map" {
+		t.Errorf("redacted() = %v, want ctx returned unchanged when message isn't a map", out["message"])
+	}
+}
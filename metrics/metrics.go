@@ -0,0 +1,71 @@
+// Package metrics exposes pggateway's Prometheus instrumentation: the
+// counters and histograms registered here are updated by the pggateway
+// package itself and scraped over a plain HTTP /metrics endpoint.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	SessionsActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pggateway_sessions_active",
+		Help: "Number of currently active client sessions.",
+	}, []string{"user", "database"})
+
+	SessionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pggateway_sessions_total",
+		Help: "Total number of client sessions handled, by how they ended.",
+	}, []string{"result"})
+
+	BytesClientToServer = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pggateway_bytes_client_to_server_total",
+		Help: "Total bytes forwarded from clients to target servers.",
+	})
+
+	BytesServerToClient = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pggateway_bytes_server_to_client_total",
+		Help: "Total bytes forwarded from target servers to clients.",
+	})
+
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pggateway_query_duration_seconds",
+		Help:    "Query duration observed at CommandComplete, by command tag.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"cmd_tag"})
+
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pggateway_auth_failures_total",
+		Help: "Total authentication failures, by method.",
+	}, []string{"method"})
+
+	PoolWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pggateway_pool_wait_seconds",
+		Help:    "Time spent waiting to acquire a pooled backend connection.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		SessionsActive,
+		SessionsTotal,
+		BytesClientToServer,
+		BytesServerToClient,
+		QueryDuration,
+		AuthFailures,
+		PoolWait,
+	)
+}
+
+// Serve starts an HTTP server exposing the registered collectors on
+// /metrics at bind (e.g. ":9187"). It blocks, so callers run it in its own
+// goroutine.
+func Serve(bind string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(bind, mux)
+}
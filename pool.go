@@ -0,0 +1,261 @@
+package pggateway
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/c653labs/pggateway/metrics"
+	"github.com/c653labs/pgproto"
+)
+
+// backendKey identifies a set of interchangeable backend connections: the
+// same user, database and startup parameters. Connections are never shared
+// across keys, since Postgres ties session state (search_path, GUCs, ...)
+// to the parameters negotiated at startup.
+type backendKey string
+
+func newBackendKey(user, database []byte, startupParams map[string][]byte) backendKey {
+	names := make([]string, 0, len(startupParams))
+	for name := range startupParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s", user, database)
+	for _, name := range names {
+		fmt.Fprintf(h, "\x00%s=%s", name, startupParams[name])
+	}
+
+	return backendKey(hex.EncodeToString(h.Sum(nil)))
+}
+
+// pooledConn is a single authenticated backend connection, plus the
+// bookkeeping TargetPool needs to recycle it.
+type pooledConn struct {
+	net.Conn
+	key       backendKey
+	createdAt time.Time
+	backendID pgproto.BackendKeyData
+
+	// statements maps a client-assigned prepared statement name to the
+	// name pggateway actually parsed it under on this specific backend
+	// connection, so transaction pooling stays correct even when the
+	// client's statement names collide across backends.
+	statements map[string]string
+	stmtSeq    int
+}
+
+func (c *pooledConn) virtualStatementName(clientName string) string {
+	if name, ok := c.statements[clientName]; ok {
+		return name
+	}
+	c.stmtSeq++
+	name := fmt.Sprintf("pggw_%d", c.stmtSeq)
+	if c.statements == nil {
+		c.statements = map[string]string{}
+	}
+	c.statements[clientName] = name
+	return name
+}
+
+// TargetPool owns the set of authenticated backend connections for a
+// target Postgres server and hands them out to sessions according to the
+// configured pool_mode, instead of every client opening its own dedicated
+// connection.
+type TargetPool struct {
+	config  TargetConfig
+	dial    func() (net.Conn, pgproto.BackendKeyData, error)
+	rawDial func() (net.Conn, error)
+
+	mu    sync.Mutex
+	idle  map[backendKey][]*pooledConn
+	count int
+
+	cancel *cancelMap
+}
+
+// NewTargetPool builds a TargetPool for a single target, dialing new
+// backend connections with dial as needed up to config.MaxConns. dial
+// completes the target's own startup/authentication handshake and returns
+// the BackendKeyData it assigned, so Cancel can later forward a
+// CancelRequest to the right real connection. rawDial opens a bare,
+// unauthenticated connection, used only to deliver that CancelRequest --
+// Postgres requires cancellation to arrive on a fresh connection, never the
+// one being canceled.
+func NewTargetPool(config TargetConfig, dial func() (net.Conn, pgproto.BackendKeyData, error), rawDial func() (net.Conn, error)) *TargetPool {
+	if config.PoolMode == "" {
+		config.PoolMode = PoolModeSession
+	}
+	return &TargetPool{
+		config:  config,
+		dial:    dial,
+		rawDial: rawDial,
+		idle:    map[backendKey][]*pooledConn{},
+		cancel:  newCancelMap(),
+	}
+}
+
+// Acquire returns a backend connection for the given key, reusing an idle
+// one if available and otherwise dialling a fresh one (blocking true
+// concurrency control is left to the caller via MaxConns bookkeeping).
+func (p *TargetPool) Acquire(key backendKey) (*pooledConn, error) {
+	start := time.Now()
+	defer func() { metrics.PoolWait.Observe(time.Since(start).Seconds()) }()
+
+	p.mu.Lock()
+	if conns := p.idle[key]; len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+		p.mu.Unlock()
+
+		if p.config.MaxLifetime > 0 && time.Since(conn.createdAt) > time.Duration(p.config.MaxLifetime)*time.Second {
+			p.Remove(conn)
+			return p.dialNew(key)
+		}
+		return conn, nil
+	}
+	p.mu.Unlock()
+
+	return p.dialNew(key)
+}
+
+func (p *TargetPool) dialNew(key backendKey) (*pooledConn, error) {
+	p.mu.Lock()
+	if p.config.MaxConns > 0 && p.count >= p.config.MaxConns {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("pool: max_conns (%d) reached for target", p.config.MaxConns)
+	}
+	p.count++
+	p.mu.Unlock()
+
+	conn, backendID, err := p.dial()
+	if err != nil {
+		p.mu.Lock()
+		p.count--
+		p.mu.Unlock()
+		return nil, err
+	}
+
+	return &pooledConn{Conn: conn, key: key, createdAt: time.Now(), backendID: backendID}, nil
+}
+
+// Release runs the configured server_reset_query (if any), draining its
+// response before returning conn to the idle set for its key, ready for
+// another session to acquire it. Skipping that drain would leave the reset
+// query's CommandComplete/ReadyForQuery sitting unread on the wire for the
+// next session's first ParseServerResponse to stumble over.
+func (p *TargetPool) Release(conn *pooledConn) {
+	if p.config.ServerResetQuery != "" {
+		if _, err := pgproto.WriteMessage(&pgproto.Query{Query: []byte(p.config.ServerResetQuery)}, conn); err != nil {
+			p.Remove(conn)
+			return
+		}
+		if err := drainToReadyForQuery(conn); err != nil {
+			p.Remove(conn)
+			return
+		}
+	}
+
+	// The next session to acquire conn may be a different client entirely;
+	// statement names virtualized for the previous tenant must not leak
+	// into its session, or a reused client-side name would silently bind
+	// against a stale statement instead of getting a fresh one.
+	conn.statements = nil
+	conn.stmtSeq = 0
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle[conn.key] = append(p.idle[conn.key], conn)
+}
+
+// drainToReadyForQuery reads and discards server messages until
+// ReadyForQuery, so a reset query's own response never ends up being read
+// by whichever session acquires conn next. A reset query that itself
+// errors (or the connection failing mid-drain) is treated as leaving conn
+// in an unknown state.
+func drainToReadyForQuery(conn *pooledConn) error {
+	for {
+		msg, err := pgproto.ParseServerMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		switch m := msg.(type) {
+		case *pgproto.Error:
+			return fmt.Errorf("pool: server_reset_query failed: %s", m.Message)
+		case *pgproto.ReadyForQuery:
+			return nil
+		}
+	}
+}
+
+// Remove drops conn from the pool entirely, e.g. after an error that leaves
+// its session state unknown.
+func (p *TargetPool) Remove(conn *pooledConn) {
+	conn.Close()
+	p.mu.Lock()
+	p.count--
+	p.mu.Unlock()
+}
+
+// Cancel forwards a CancelRequest for the gateway-issued clientKey to
+// whichever real backend connection currently owns it, if any -- a cancel
+// that arrives once the owning session has already released or abandoned
+// its connection has nothing left to target and is silently dropped, same
+// as Postgres itself does for a cancel that loses the race.
+func (p *TargetPool) Cancel(clientKey int32) error {
+	conn, ok := p.cancel.resolve(clientKey)
+	if !ok {
+		return nil
+	}
+
+	raw, err := p.rawDial()
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+
+	_, err = pgproto.WriteMessage(&pgproto.CancelRequest{
+		ProcessID: conn.backendID.ProcessID,
+		SecretKey: conn.backendID.SecretKey,
+	}, raw)
+	return err
+}
+
+// cancelMap lets CancelRequests addressed to the gateway-issued
+// BackendKeyData be routed to whichever real backend connection currently
+// owns that key, since pooling means the client's cancel key no longer
+// matches any single long-lived backend connection.
+type cancelMap struct {
+	mu    sync.Mutex
+	owner map[int32]*pooledConn
+}
+
+func newCancelMap() *cancelMap {
+	return &cancelMap{owner: map[int32]*pooledConn{}}
+}
+
+func (m *cancelMap) track(clientKey int32, conn *pooledConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.owner[clientKey] = conn
+}
+
+func (m *cancelMap) untrack(clientKey int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.owner, clientKey)
+}
+
+func (m *cancelMap) resolve(clientKey int32) (*pooledConn, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conn, ok := m.owner[clientKey]
+	return conn, ok
+}
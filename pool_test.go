@@ -0,0 +1,50 @@
+package pggateway
+
+import "testing"
+
+func TestVirtualStatementName(t *testing.T) {
+	conn := &pooledConn{}
+
+	first := conn.virtualStatementName("client_stmt")
+	if first == "" {
+		t.Fatal("virtualStatementName returned an empty name")
+	}
+
+	// Asking again for the same client-side name must return the same
+	// virtual name, not allocate a new one.
+	again := conn.virtualStatementName("client_stmt")
+	if again != first {
+		t.Errorf("virtualStatementName(%q) = %q on second call, want %q", "client_stmt", again, first)
+	}
+
+	// A different client-side name gets a distinct virtual name.
+	other := conn.virtualStatementName("other_stmt")
+	if other == first {
+		t.Errorf("virtualStatementName(%q) and virtualStatementName(%q) collided on %q", "client_stmt", "other_stmt", other)
+	}
+}
+
+func TestVirtualStatementNameResetAfterRelease(t *testing.T) {
+	conn := &pooledConn{}
+
+	conn.virtualStatementName("client_stmt")
+	conn.virtualStatementName("other_stmt")
+
+	// Release clears the statement cache so a reused physical connection
+	// doesn't carry over a previous tenant's client-side name mappings.
+	NewTargetPool(TargetConfig{}, nil, nil).Release(conn)
+
+	if len(conn.statements) != 0 {
+		t.Fatalf("statements = %v after reset, want empty", conn.statements)
+	}
+
+	// A name that collided with the previous tenant's must be resolved
+	// fresh, not returned stale from before the reset.
+	name := conn.virtualStatementName("client_stmt")
+	if name == "" {
+		t.Fatal("virtualStatementName returned an empty name after reset")
+	}
+	if len(conn.statements) != 1 {
+		t.Errorf("statements = %v after reassignment, want exactly one entry", conn.statements)
+	}
+}
@@ -0,0 +1,309 @@
+package pggateway
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c653labs/pgproto"
+)
+
+// sideEffectingFuncs lists call-like tokens that disqualify an otherwise
+// read-only-looking statement (SELECT nextval('seq'), SELECT pg_advisory_lock(...), ...)
+// from being routed to a replica.
+var sideEffectingFuncs = []string{"nextval", "setval", "pg_advisory_lock", "pg_advisory_xact_lock"}
+
+// readOnlyKeywords are the leading keywords of statements that never
+// mutate data on their own.
+var readOnlyKeywords = map[string]bool{
+	"select":  true,
+	"show":    true,
+	"explain": true,
+}
+
+// classifyQuery is a lightweight SQL tokenizer: it looks at the first
+// keyword of sql to decide whether the statement can safely be routed to a
+// replica. It intentionally does not attempt to parse SQL in full -- it
+// only needs to be conservative, routing anything it isn't sure about to
+// the primary.
+func classifyQuery(sql []byte) (readOnly bool) {
+	trimmed := bytes.TrimSpace(sql)
+	if len(trimmed) == 0 {
+		return false
+	}
+
+	first := strings.ToLower(firstWord(trimmed))
+	if !readOnlyKeywords[first] {
+		return false
+	}
+
+	lower := strings.ToLower(string(trimmed))
+	for _, fn := range sideEffectingFuncs {
+		if strings.Contains(lower, fn+"(") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func firstWord(sql []byte) string {
+	end := bytes.IndexAny(sql, " \t\r\n(")
+	if end == -1 {
+		return string(sql)
+	}
+	return string(sql[:end])
+}
+
+// classifyTransactionHint looks for BEGIN READ ONLY / SET TRANSACTION READ
+// ONLY (or READ WRITE) hints, which override per-statement classification
+// for the rest of the transaction.
+func classifyTransactionHint(sql []byte) (readOnly bool, ok bool) {
+	lower := strings.ToLower(strings.TrimSpace(string(sql)))
+	switch {
+	case strings.HasPrefix(lower, "begin") || strings.HasPrefix(lower, "start transaction") || strings.HasPrefix(lower, "set transaction"):
+		if strings.Contains(lower, "read only") {
+			return true, true
+		}
+		if strings.Contains(lower, "read write") {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// replicaTarget is a single replica pool plus the health state the
+// QueryRouter's background checker maintains for it.
+type replicaTarget struct {
+	name string
+	pool *TargetPool
+	dial func() (net.Conn, error)
+
+	healthy int32 // atomic bool: 1 == eligible to serve reads
+}
+
+func (r *replicaTarget) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replicaTarget) setHealthy(healthy bool) {
+	v := int32(0)
+	if healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&r.healthy, v)
+}
+
+// QueryRouter inspects classified transactions and dispatches them to a
+// primary or replica TargetPool, falling back to the primary whenever it
+// isn't confident a statement is read-only or no replica is healthy.
+type QueryRouter struct {
+	primary     *TargetPool
+	primaryDial func() (net.Conn, error)
+
+	mu          sync.RWMutex
+	replicas    []*replicaTarget
+	nextReplica uint64
+
+	maxLagBytes int64
+}
+
+// NewQueryRouter builds a QueryRouter for a single listener from its
+// RoutingConfig and the listener's primary pool; replica pools are
+// registered afterwards with AddReplica. primaryDial opens a
+// fresh administrative connection to the primary, used only to read its
+// current WAL position when checking replica lag.
+func NewQueryRouter(config RoutingConfig, primary *TargetPool, primaryDial func() (net.Conn, error)) *QueryRouter {
+	return &QueryRouter{primary: primary, primaryDial: primaryDial, maxLagBytes: config.MaxReplicaLagBytes}
+}
+
+// AddReplica registers a replica pool with the router. dial opens a fresh
+// administrative connection used only for health checks, separate from
+// pooled client connections.
+func (r *QueryRouter) AddReplica(name string, pool *TargetPool, dial func() (net.Conn, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replicas = append(r.replicas, &replicaTarget{name: name, pool: pool, dial: dial, healthy: 1})
+}
+
+// Pool picks the TargetPool that should serve a transaction, given whether
+// it was classified as read-only.
+func (r *QueryRouter) Pool(readOnly bool) *TargetPool {
+	if !readOnly {
+		return r.primary
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	healthy := make([]*replicaTarget, 0, len(r.replicas))
+	for _, replica := range r.replicas {
+		if replica.isHealthy() {
+			healthy = append(healthy, replica)
+		}
+	}
+	if len(healthy) == 0 {
+		return r.primary
+	}
+
+	i := atomic.AddUint64(&r.nextReplica, 1)
+	return healthy[i%uint64(len(healthy))].pool
+}
+
+// RunHealthChecks periodically compares the primary's pg_current_wal_lsn()
+// against every replica's pg_is_in_recovery()/pg_last_wal_replay_lsn(),
+// evicting (and later reinstating) any replica whose lag behind the
+// primary exceeds maxLagBytes or that fails to respond, until stop is
+// closed.
+func (r *QueryRouter) RunHealthChecks(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.checkReplicas()
+		}
+	}
+}
+
+func (r *QueryRouter) checkReplicas() {
+	r.mu.RLock()
+	replicas := append([]*replicaTarget(nil), r.replicas...)
+	r.mu.RUnlock()
+
+	// Lag is only meaningful relative to the primary's current WAL
+	// position, so fetch it once per round rather than once per replica.
+	// If the primary can't be reached, we have no basis to call any
+	// replica's lag acceptable, so fail safe and evict them all.
+	primaryLSN, err := checkPrimaryLSN(r.primaryDial)
+	if err != nil {
+		for _, replica := range replicas {
+			replica.setHealthy(false)
+		}
+		return
+	}
+
+	for _, replica := range replicas {
+		replicaLSN, inRecovery, err := checkReplicaLag(replica.dial)
+		if err != nil || !inRecovery {
+			replica.setHealthy(false)
+			continue
+		}
+
+		lagBytes := primaryLSN - replicaLSN
+		if lagBytes < 0 {
+			lagBytes = 0
+		}
+		replica.setHealthy(r.maxLagBytes <= 0 || lagBytes <= r.maxLagBytes)
+	}
+}
+
+// checkPrimaryLSN opens an administrative connection via dial and runs
+// `SELECT pg_current_wal_lsn()`, returning the primary's current WAL
+// position as a byte offset so it can be compared against each replica's
+// replay position.
+func checkPrimaryLSN(dial func() (net.Conn, error)) (lsn int64, err error) {
+	conn, err := dial()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if _, err := pgproto.WriteMessage(&pgproto.Query{
+		Query: []byte("SELECT pg_current_wal_lsn()"),
+	}, conn); err != nil {
+		return 0, err
+	}
+
+	for {
+		msg, err := pgproto.ParseServerMessage(conn)
+		if err != nil {
+			return 0, err
+		}
+
+		switch m := msg.(type) {
+		case *pgproto.DataRow:
+			if len(m.Values) < 1 {
+				return 0, fmt.Errorf("router: unexpected health-check row")
+			}
+			lsn, err = parseLSN(string(m.Values[0]))
+			if err != nil {
+				return 0, err
+			}
+		case *pgproto.Error:
+			return 0, fmt.Errorf("router: health-check query failed: %s", m.Message)
+		case *pgproto.ReadyForQuery:
+			return lsn, nil
+		}
+	}
+}
+
+// checkReplicaLag opens an administrative connection via dial and runs
+// `SELECT pg_is_in_recovery(), pg_last_wal_replay_lsn()`, returning the
+// replay LSN converted to a byte offset. The caller compares this against
+// the primary's current position (from checkPrimaryLSN) to compute actual
+// lag, since the replica's raw position means nothing on its own.
+func checkReplicaLag(dial func() (net.Conn, error)) (replicaLSN int64, inRecovery bool, err error) {
+	conn, err := dial()
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+
+	if _, err := pgproto.WriteMessage(&pgproto.Query{
+		Query: []byte("SELECT pg_is_in_recovery(), pg_last_wal_replay_lsn()"),
+	}, conn); err != nil {
+		return 0, false, err
+	}
+
+	for {
+		msg, err := pgproto.ParseServerMessage(conn)
+		if err != nil {
+			return 0, false, err
+		}
+
+		switch m := msg.(type) {
+		case *pgproto.DataRow:
+			if len(m.Values) < 2 {
+				return 0, false, fmt.Errorf("router: unexpected health-check row")
+			}
+			inRecovery = string(m.Values[0]) == "t"
+			lsn, err := parseLSN(string(m.Values[1]))
+			if err != nil {
+				return 0, false, err
+			}
+			replicaLSN = lsn
+		case *pgproto.Error:
+			return 0, false, fmt.Errorf("router: health-check query failed: %s", m.Message)
+		case *pgproto.ReadyForQuery:
+			return replicaLSN, inRecovery, nil
+		}
+	}
+}
+
+// parseLSN converts a Postgres LSN ("16/B374D848") into a byte offset.
+func parseLSN(lsn string) (int64, error) {
+	parts := strings.SplitN(lsn, "/", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("router: malformed LSN %q", lsn)
+	}
+
+	hi, err := strconv.ParseInt(parts[0], 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	lo, err := strconv.ParseInt(parts[1], 16, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return hi<<32 | lo, nil
+}
@@ -0,0 +1,92 @@
+package pggateway
+
+import "testing"
+
+func TestClassifyQuery(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		readOnly bool
+	}{
+		{"select", "SELECT * FROM users", true},
+		{"lowercase select", "select 1", true},
+		{"show", "SHOW search_path", true},
+		{"explain", "EXPLAIN SELECT 1", true},
+		{"insert", "INSERT INTO users (id) VALUES (1)", false},
+		{"update", "UPDATE users SET id = 1", false},
+		{"delete", "DELETE FROM users", false},
+		{"empty", "", false},
+		{"whitespace only", "   \n\t", false},
+		{"select with nextval", "SELECT nextval('users_id_seq')", false},
+		{"select with setval", "select setval('users_id_seq', 1)", false},
+		{"select with advisory lock", "SELECT pg_advisory_lock(1)", false},
+		{"select with advisory xact lock", "select pg_advisory_xact_lock(1)", false},
+		{"leading whitespace select", "  \n  SELECT 1", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := classifyQuery([]byte(c.sql)); got != c.readOnly {
+				t.Errorf("classifyQuery(%q) = %v, want %v", c.sql, got, c.readOnly)
+			}
+		})
+	}
+}
+
+func TestClassifyTransactionHint(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		readOnly bool
+		ok       bool
+	}{
+		{"begin read only", "BEGIN READ ONLY", true, true},
+		{"begin read write", "BEGIN READ WRITE", false, true},
+		{"start transaction read only", "start transaction read only", true, true},
+		{"set transaction read only", "SET TRANSACTION READ ONLY", true, true},
+		{"begin with no hint", "BEGIN", false, false},
+		{"unrelated statement", "SELECT 1", false, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			readOnly, ok := classifyTransactionHint([]byte(c.sql))
+			if ok != c.ok || readOnly != c.readOnly {
+				t.Errorf("classifyTransactionHint(%q) = (%v, %v), want (%v, %v)", c.sql, readOnly, ok, c.readOnly, c.ok)
+			}
+		})
+	}
+}
+
+func TestParseLSN(t *testing.T) {
+	cases := []struct {
+		lsn     string
+		want    int64
+		wantErr bool
+	}{
+		{"0/0", 0, false},
+		{"16/B374D848", 0x16<<32 | 0xB374D848, false},
+		{"FFFFFFFF/FFFFFFFF", 0xFFFFFFFF<<32 | 0xFFFFFFFF, false},
+		{"not-a-lsn", 0, true},
+		{"16", 0, true},
+		{"ZZ/ZZ", 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.lsn, func(t *testing.T) {
+			got, err := parseLSN(c.lsn)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseLSN(%q) = %d, nil, want error", c.lsn, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLSN(%q) returned unexpected error: %v", c.lsn, err)
+			}
+			if got != c.want {
+				t.Errorf("parseLSN(%q) = %d, want %d", c.lsn, got, c.want)
+			}
+		})
+	}
+}
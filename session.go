@@ -5,8 +5,11 @@ import (
 	"io"
 	"net"
 	"sync"
+	"time"
 
+	"github.com/c653labs/pggateway/metrics"
 	"github.com/c653labs/pgproto"
+	"github.com/prometheus/client_golang/prometheus"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -25,7 +28,24 @@ type Session struct {
 
 	stopped bool
 
-	plugins *PluginRegistry
+	plugins      *PluginRegistry
+	interceptors []QueryInterceptor
+	interceptor  *interceptorState
+
+	queryLogger *ZapLoggingPlugin
+	queryStart  time.Time
+	querySQL    []byte
+	rowCount    int64
+
+	pool            *TargetPool
+	poolMode        string
+	backendKey      backendKey
+	backendConn     *pooledConn
+	clientCancelKey int32
+
+	router        *QueryRouter
+	txReadOnly    bool
+	txReadOnlySet bool
 }
 
 func NewSession(startup *pgproto.StartupMessage, user []byte, database []byte, isSSL bool, client net.Conn, target net.Conn, plugins *PluginRegistry) (*Session, error) {
@@ -36,20 +56,138 @@ func NewSession(startup *pgproto.StartupMessage, user []byte, database []byte, i
 	}
 
 	return &Session{
-		ID:       id.String(),
-		User:     user,
-		Database: database,
-		IsSSL:    isSSL,
-		client:   client,
-		target:   target,
-		salt:     generateSalt(),
-		startup:  startup,
-		plugins:  plugins,
-		stopped:  false,
+		ID:          id.String(),
+		User:        user,
+		Database:    database,
+		IsSSL:       isSSL,
+		client:      client,
+		target:      target,
+		salt:        generateSalt(),
+		startup:     startup,
+		plugins:     plugins,
+		stopped:     false,
+		interceptor: newInterceptorState(),
 	}, nil
 }
 
+// SetInterceptors installs the QueryInterceptor chain that mediates this
+// session's extended query protocol traffic. It must be called before
+// Handle; a nil or empty chain leaves the proxy behaving as a byte-for-byte
+// passthrough.
+func (s *Session) SetInterceptors(interceptors []QueryInterceptor) {
+	s.interceptors = interceptors
+}
+
+// SetQueryLogger installs the structured query logger used to emit one
+// LogQuery event per Parse/Query, alongside its duration and row count.
+func (s *Session) SetQueryLogger(logger *ZapLoggingPlugin) {
+	s.queryLogger = logger
+}
+
+// SetPool switches the session from a dedicated backend connection to one
+// drawn from pool under the given pool_mode (PoolModeSession is equivalent
+// to the session's original dedicated-connection behaviour). clientCancelKey
+// is the BackendKeyData.ProcessID the gateway issued to the client, used to
+// route CancelRequests to whichever backend currently serves this session.
+func (s *Session) SetPool(pool *TargetPool, mode string, clientCancelKey int32) {
+	s.pool = pool
+	s.poolMode = mode
+	s.clientCancelKey = clientCancelKey
+	s.backendKey = newBackendKey(s.User, s.Database, s.startup.Options)
+}
+
+// SetRouter installs the QueryRouter used to pick which pool (primary or a
+// replica) serves each transaction. It only has an effect once SetPool has
+// also been called, since the router dispatches between pools rather than
+// replacing pooling itself.
+func (s *Session) SetRouter(router *QueryRouter) {
+	s.router = router
+}
+
+// acquireBackend checks out a pooled backend connection and makes it the
+// session's target, if one isn't already checked out.
+func (s *Session) acquireBackend() error {
+	if s.pool == nil || s.backendConn != nil {
+		return nil
+	}
+
+	conn, err := s.pool.Acquire(s.backendKey)
+	if err != nil {
+		return err
+	}
+
+	s.backendConn = conn
+	s.target = conn
+	s.pool.cancel.track(s.clientCancelKey, conn)
+	return nil
+}
+
+// releaseBackend returns the session's checked-out backend connection to
+// the pool, leaving s.target unset until the next message needs one.
+func (s *Session) releaseBackend() {
+	if s.pool == nil || s.backendConn == nil {
+		return
+	}
+
+	s.pool.cancel.untrack(s.clientCancelKey)
+	s.pool.Release(s.backendConn)
+	s.backendConn = nil
+	s.target = nil
+}
+
+// abandonBackend drops the session's checked-out backend connection from
+// the pool entirely, instead of recycling it, because an I/O error leaves
+// its session state (or its liveness) unknown. Without this, every backend
+// error would leak a pooled connection -- Release/Remove would never run,
+// and TargetPool.count would stay incremented forever, eventually wedging
+// the pool at max_conns even though no connections are actually in use.
+func (s *Session) abandonBackend() {
+	if s.pool == nil || s.backendConn == nil {
+		return
+	}
+
+	s.pool.cancel.untrack(s.clientCancelKey)
+	s.pool.Remove(s.backendConn)
+	s.backendConn = nil
+	s.target = nil
+}
+
+// virtualizeStatementName rewrites the client-assigned prepared statement
+// name on Parse/Bind/Close/Describe messages to the name pggateway actually
+// parsed it under on the checked-out backend connection, so statement names
+// stay unambiguous even though the same client name may land on a different
+// physical connection across transactions.
+func (s *Session) virtualizeStatementName(msg pgproto.ClientMessage) pgproto.ClientMessage {
+	if s.backendConn == nil {
+		return msg
+	}
+
+	switch m := msg.(type) {
+	case *pgproto.Parse:
+		if len(m.Name) > 0 {
+			m.Name = []byte(s.backendConn.virtualStatementName(string(m.Name)))
+		}
+	case *pgproto.Bind:
+		if len(m.Statement) > 0 {
+			m.Statement = []byte(s.backendConn.virtualStatementName(string(m.Statement)))
+		}
+	case *pgproto.Describe:
+		if m.Kind == pgproto.DescribeStatement && len(m.Name) > 0 {
+			m.Name = []byte(s.backendConn.virtualStatementName(string(m.Name)))
+		}
+	case *pgproto.Close:
+		if m.Kind == pgproto.CloseStatement && len(m.Name) > 0 {
+			m.Name = []byte(s.backendConn.virtualStatementName(string(m.Name)))
+		}
+	}
+	return msg
+}
+
 func (s *Session) Close() {
+	if s.backendConn != nil {
+		s.abandonBackend()
+		return
+	}
 	if s.target != nil {
 		s.target.Close()
 	}
@@ -62,10 +200,12 @@ func (s *Session) String() string {
 func (s *Session) Handle() error {
 	success, err := s.plugins.Authenticate(s, s.startup)
 	if err != nil {
+		metrics.SessionsTotal.WithLabelValues("error").Inc()
 		return err
 	}
 
 	if !success {
+		metrics.SessionsTotal.WithLabelValues("auth_failed").Inc()
 		errMsg := &pgproto.Error{
 			Severity: []byte("Fatal"),
 			Message:  []byte("failed to authenticate"),
@@ -74,7 +214,31 @@ func (s *Session) Handle() error {
 		return nil
 	}
 
-	return s.proxy()
+	labels := prometheus.Labels{"user": string(s.User), "database": string(s.Database)}
+	metrics.SessionsActive.With(labels).Inc()
+	defer metrics.SessionsActive.With(labels).Dec()
+
+	// pool_mode: session checks out one backend connection for the whole
+	// session, same as pggateway's original dedicated-connection behaviour,
+	// except the connection is drawn from (and returned to) the TargetPool
+	// so it's still subject to max_conns accounting.
+	if s.poolMode == PoolModeSession {
+		if err := s.acquireBackend(); err != nil {
+			metrics.SessionsTotal.WithLabelValues("error").Inc()
+			return err
+		}
+	}
+
+	err = s.proxy()
+	result := "ok"
+	if err != nil {
+		result = "error"
+		s.abandonBackend()
+	} else {
+		s.releaseBackend()
+	}
+	metrics.SessionsTotal.WithLabelValues(result).Inc()
+	return err
 }
 
 func (s *Session) GetUserPassword(method pgproto.AuthenticationMethod) (*pgproto.AuthenticationRequest, *pgproto.PasswordMessage, error) {
@@ -131,50 +295,64 @@ func (s *Session) parseStartupMessage() (*pgproto.StartupMessage, error) {
 func (s *Session) proxy() error {
 	m := &sync.Mutex{}
 	stop := sync.NewCond(m)
-	errs := make([]error, 0)
-
-	go s.proxyClientMessages(stop, errs)
-	go s.proxyServerMessages(stop, errs)
-
-	// Disable message interception
-	// go func() {
-	//	_, err := io.Copy(s.client, s.target)
-	//	errs = append(errs, err)
-	//	stop.Broadcast()
-	// }()
+	var errs []error
+
+	// recordErr is shared (by closure, not by value) with both proxy
+	// goroutines, so an error either one hits is actually visible here --
+	// passing errs itself to each goroutine would let their independent
+	// appends reallocate their own local copies, leaving this one stuck at
+	// len(errs) == 0 forever.
+	recordErr := func(err error) {
+		m.Lock()
+		errs = append(errs, err)
+		m.Unlock()
+		stop.Broadcast()
+	}
 
-	// go func() {
-	//	_, err := io.Copy(s.target, s.client)
-	//	errs = append(errs, err)
-	//	stop.Broadcast()
-	// }()
+	go s.proxyClientMessages(stop, recordErr)
+	go s.proxyServerMessages(stop, recordErr)
 
 	stop.L.Lock()
 	stop.Wait()
 	stop.L.Unlock()
 	s.stopped = true
 
+	m.Lock()
+	defer m.Unlock()
 	if len(errs) > 0 {
 		return errs[0]
 	}
 	return nil
 }
 
-func (s *Session) proxyServerMessages(stop *sync.Cond, errs []error) {
+func (s *Session) proxyServerMessages(stop *sync.Cond, recordErr func(error)) {
 	var buf []pgproto.Message
 	for !s.stopped {
 		msg, err := s.ParseServerResponse()
 		if err != nil {
-			errs = append(errs, err)
-			stop.Broadcast()
+			s.abandonBackend()
+			recordErr(err)
 			break
 		}
-		buf = append(buf, msg)
+
+		out, err := s.interceptServerMessage(msg)
+		if err != nil {
+			s.abandonBackend()
+			recordErr(err)
+			break
+		}
+		if out != nil {
+			buf = append(buf, out)
+		}
 
 		flush := false
 		switch m := msg.(type) {
 		case *pgproto.ReadyForQuery:
 			flush = true
+			if s.poolMode == PoolModeTransaction || s.poolMode == PoolModeStatement {
+				s.releaseBackend()
+			}
+			s.txReadOnlySet = false
 		case *pgproto.AuthenticationRequest:
 			flush = m.Method != pgproto.AuthenticationMethodOK
 		}
@@ -188,16 +366,41 @@ func (s *Session) proxyServerMessages(stop *sync.Cond, errs []error) {
 	}
 }
 
-func (s *Session) proxyClientMessages(stop *sync.Cond, errs []error) {
+func (s *Session) proxyClientMessages(stop *sync.Cond, recordErr func(error)) {
 	for !s.stopped {
 		msg, err := s.ParseClientRequest()
 		if err != nil {
-			errs = append(errs, err)
-			stop.Broadcast()
+			s.abandonBackend()
+			recordErr(err)
 			break
 		}
 
-		s.WriteToServer(msg)
+		out, synthetic, err := s.interceptClientMessage(msg)
+		if err != nil {
+			s.abandonBackend()
+			recordErr(err)
+			break
+		}
+		for _, reply := range synthetic {
+			s.WriteToClient(reply)
+		}
+		if out != nil {
+			if s.poolMode == PoolModeTransaction || s.poolMode == PoolModeStatement {
+				if s.router != nil && s.backendConn == nil {
+					s.pool = s.router.Pool(s.txReadOnly)
+				}
+				if err := s.acquireBackend(); err != nil {
+					recordErr(err)
+					break
+				}
+				out = s.virtualizeStatementName(out)
+			}
+			if err := s.WriteToServer(out); err != nil {
+				s.abandonBackend()
+				recordErr(err)
+				break
+			}
+		}
 
 		if _, ok := msg.(*pgproto.Termination); ok {
 			break
@@ -205,13 +408,192 @@ func (s *Session) proxyClientMessages(stop *sync.Cond, errs []error) {
 	}
 }
 
+// interceptClientMessage runs msg through the registered QueryInterceptor
+// chain, keeping the per-session prepared statement and portal tables in
+// sync along the way. It returns the (possibly rewritten) message to
+// forward to the backend (nil if it should not be forwarded at all), any
+// synthetic replies to send straight back to the client, and an error.
+func (s *Session) interceptClientMessage(msg pgproto.ClientMessage) (pgproto.ClientMessage, []pgproto.ServerMessage, error) {
+	switch m := msg.(type) {
+	case *pgproto.Parse:
+		s.classifyForRouting(m.Query)
+		stmt := &PreparedStatement{Name: string(m.Name), SQL: m.Query, ParamOIDs: m.ParameterOIDs}
+		result, err := runInterceptors(s.interceptors, func(i QueryInterceptor) (*InterceptResult, error) {
+			return i.OnParse(s, stmt, m)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		s.interceptor.storeStatement(stmt)
+		return applyClientResult(m, result)
+
+	case *pgproto.Bind:
+		stmt := s.interceptor.statements[string(m.Statement)]
+		portal := &Portal{Name: string(m.Portal), Statement: stmt, ParamFormats: m.ParameterFormats, ResultFormats: m.ResultFormats}
+		result, err := runInterceptors(s.interceptors, func(i QueryInterceptor) (*InterceptResult, error) {
+			return i.OnBind(s, portal, m)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		s.interceptor.storePortal(portal)
+		return applyClientResult(m, result)
+
+	case *pgproto.Query:
+		s.classifyForRouting(m.Query)
+		s.startQuery(m.Query)
+		result, err := runInterceptors(s.interceptors, func(i QueryInterceptor) (*InterceptResult, error) {
+			return i.OnQuery(s, m)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return applyClientResult(m, result)
+
+	case *pgproto.Execute:
+		if portal, ok := s.interceptor.portals[string(m.Portal)]; ok && portal.Statement != nil {
+			s.startQuery(portal.Statement.SQL)
+		}
+
+	case *pgproto.Close:
+		switch m.Kind {
+		case pgproto.CloseStatement:
+			s.interceptor.closeStatement(string(m.Name))
+		case pgproto.ClosePortal:
+			s.interceptor.closePortal(string(m.Name))
+		}
+	}
+
+	return msg, nil, nil
+}
+
+func (s *Session) interceptServerMessage(msg pgproto.ServerMessage) (pgproto.ServerMessage, error) {
+	switch m := msg.(type) {
+	case *pgproto.RowDescription:
+		result, err := runInterceptors(s.interceptors, func(i QueryInterceptor) (*InterceptResult, error) {
+			return i.OnRowDescription(s, m)
+		})
+		return applyServerResult(m, result, err)
+
+	case *pgproto.DataRow:
+		s.rowCount++
+		result, err := runInterceptors(s.interceptors, func(i QueryInterceptor) (*InterceptResult, error) {
+			return i.OnDataRow(s, m)
+		})
+		return applyServerResult(m, result, err)
+
+	case *pgproto.CommandComplete:
+		result, err := runInterceptors(s.interceptors, func(i QueryInterceptor) (*InterceptResult, error) {
+			return i.OnCommandComplete(s, m)
+		})
+		// pool_mode: statement releases on the following ReadyForQuery (see
+		// proxyServerMessages), not here -- a Sync (or further pipelined
+		// Bind/Execute ahead of one) can still be coming for this backend,
+		// and releasing early would hand it to an unrelated session mid
+		// extended-query.
+		s.finishQuery(m.Tag)
+		return applyServerResult(m, result, err)
+	}
+
+	return msg, nil
+}
+
+// classifyForRouting updates the session's sticky read-only classification
+// for the in-flight transaction: an explicit BEGIN READ ONLY / SET
+// TRANSACTION hint wins outright, otherwise the first statement's
+// classification (once set) holds for the rest of the transaction so a
+// later write doesn't get misrouted to a replica.
+func (s *Session) classifyForRouting(sql []byte) {
+	if s.router == nil {
+		return
+	}
+	if hint, ok := classifyTransactionHint(sql); ok {
+		s.txReadOnly = hint
+		s.txReadOnlySet = true
+		return
+	}
+	if !s.txReadOnlySet {
+		s.txReadOnly = classifyQuery(sql)
+		s.txReadOnlySet = true
+	}
+}
+
+func (s *Session) startQuery(sql []byte) {
+	s.queryStart = time.Now()
+	s.querySQL = sql
+	s.rowCount = 0
+}
+
+func (s *Session) finishQuery(cmdTag []byte) {
+	if s.queryStart.IsZero() {
+		return
+	}
+	duration := time.Since(s.queryStart)
+	metrics.QueryDuration.WithLabelValues(commandTag(cmdTag)).Observe(duration.Seconds())
+
+	if s.queryLogger != nil {
+		s.queryLogger.LogQuery(s, s.querySQL, duration, s.rowCount)
+	}
+	s.queryStart = time.Time{}
+}
+
+// commandTag extracts the leading verb of a CommandComplete tag (e.g.
+// "SELECT" from "SELECT 5") for use as a low-cardinality metric label.
+func commandTag(tag []byte) string {
+	for i, b := range tag {
+		if b == ' ' {
+			return string(tag[:i])
+		}
+	}
+	return string(tag)
+}
+
+func applyClientResult(original pgproto.ClientMessage, result *InterceptResult) (pgproto.ClientMessage, []pgproto.ServerMessage, error) {
+	if result == nil {
+		return original, nil, nil
+	}
+	if result.Drop {
+		return nil, result.Synthetic, nil
+	}
+	if result.Forward != nil {
+		rewritten, ok := result.Forward.(pgproto.ClientMessage)
+		if !ok {
+			return nil, nil, fmt.Errorf("interceptor replaced client message with incompatible type %T", result.Forward)
+		}
+		return rewritten, result.Synthetic, nil
+	}
+	return original, result.Synthetic, nil
+}
+
+func applyServerResult(original pgproto.ServerMessage, result *InterceptResult, err error) (pgproto.ServerMessage, error) {
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return original, nil
+	}
+	if result.Drop {
+		return nil, nil
+	}
+	if result.Forward != nil {
+		rewritten, ok := result.Forward.(pgproto.ServerMessage)
+		if !ok {
+			return nil, fmt.Errorf("interceptor replaced server message with incompatible type %T", result.Forward)
+		}
+		return rewritten, nil
+	}
+	return original, nil
+}
+
 func (s *Session) WriteToServer(msg pgproto.ClientMessage) error {
-	_, err := pgproto.WriteMessage(msg, s.target)
+	n, err := pgproto.WriteMessage(msg, s.target)
+	metrics.BytesClientToServer.Add(float64(n))
 	return err
 }
 
 func (s *Session) WriteToClient(msg pgproto.ServerMessage) error {
-	_, err := pgproto.WriteMessage(msg, s.client)
+	n, err := pgproto.WriteMessage(msg, s.client)
+	metrics.BytesServerToClient.Add(float64(n))
 	return err
 }
 
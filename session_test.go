@@ -0,0 +1,107 @@
+package pggateway
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c653labs/pgproto"
+)
+
+func TestCommandTag(t *testing.T) {
+	cases := []struct {
+		tag  string
+		want string
+	}{
+		{tag: "SELECT 5", want: "SELECT"},
+		{tag: "INSERT 0 1", want: "INSERT"},
+		{tag: "BEGIN", want: "BEGIN"},
+		{tag: "", want: ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.tag, func(t *testing.T) {
+			if got := commandTag([]byte(tc.tag)); got != tc.want {
+				t.Errorf("commandTag(%q) = %q, want %q", tc.tag, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplyClientResult(t *testing.T) {
+	original := &pgproto.Query{Query: []byte("select 1")}
+	replacement := &pgproto.Query{Query: []byte("select 2")}
+	synthetic := []pgproto.ServerMessage{&pgproto.CommandComplete{Tag: []byte("SELECT 1")}}
+
+	cases := []struct {
+		name      string
+		result    *InterceptResult
+		wantMsg   pgproto.ClientMessage
+		wantSynth []pgproto.ServerMessage
+		wantErr   bool
+	}{
+		{name: "nil result forwards original unchanged", result: nil, wantMsg: original},
+		{name: "drop discards the message but keeps synthetic replies", result: &InterceptResult{Drop: true, Synthetic: synthetic}, wantMsg: nil, wantSynth: synthetic},
+		{name: "forward replaces the message", result: &InterceptResult{Forward: replacement}, wantMsg: replacement},
+		{name: "result with no Drop or Forward still forwards original", result: &InterceptResult{Synthetic: synthetic}, wantMsg: original, wantSynth: synthetic},
+		{name: "forward with incompatible type errors", result: &InterceptResult{Forward: &pgproto.CommandComplete{}}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, synth, err := applyClientResult(original, tc.result)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("applyClientResult() err = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyClientResult() unexpected error: %v", err)
+			}
+			if msg != tc.wantMsg {
+				t.Errorf("applyClientResult() msg = %v, want %v", msg, tc.wantMsg)
+			}
+			if len(synth) != len(tc.wantSynth) {
+				t.Errorf("applyClientResult() synthetic = %v, want %v", synth, tc.wantSynth)
+			}
+		})
+	}
+}
+
+func TestApplyServerResult(t *testing.T) {
+	original := &pgproto.CommandComplete{Tag: []byte("SELECT 1")}
+	replacement := &pgproto.CommandComplete{Tag: []byte("SELECT 0")}
+	upstreamErr := errors.New("read failed")
+
+	cases := []struct {
+		name    string
+		result  *InterceptResult
+		err     error
+		wantMsg pgproto.ServerMessage
+		wantErr error
+	}{
+		{name: "upstream error passes through untouched", result: &InterceptResult{Drop: true}, err: upstreamErr, wantErr: upstreamErr},
+		{name: "nil result forwards original unchanged", result: nil, wantMsg: original},
+		{name: "drop discards the message", result: &InterceptResult{Drop: true}, wantMsg: nil},
+		{name: "forward replaces the message", result: &InterceptResult{Forward: replacement}, wantMsg: replacement},
+		{name: "forward with incompatible type errors", result: &InterceptResult{Forward: &pgproto.Query{}}, wantErr: errors.New("interceptor replaced server message with incompatible type *pgproto.Query")},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := applyServerResult(original, tc.result, tc.err)
+			if tc.wantErr != nil {
+				if err == nil {
+					t.Fatalf("applyServerResult() err = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyServerResult() unexpected error: %v", err)
+			}
+			if msg != tc.wantMsg {
+				t.Errorf("applyServerResult() msg = %v, want %v", msg, tc.wantMsg)
+			}
+		})
+	}
+}
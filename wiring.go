@@ -0,0 +1,121 @@
+package pggateway
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/c653labs/pgproto"
+)
+
+// BuildTargetPools builds a TargetPool for every target in cfg.Targets,
+// splitting them by TargetRoleConfig.Role. dial completes a fresh,
+// authenticated backend connection for a given target (host/port/sslmode
+// already resolved by the caller) and returns the BackendKeyData the target
+// assigned it; rawDial opens a bare connection to the same target, used
+// only to deliver CancelRequests. Each TargetPool binds them to its own
+// target; dial is then called for every new backend connection and rawDial
+// for every CancelRequest.
+//
+// Exactly one primary is expected; replicas are returned keyed by the name
+// namedTargets gives them ("target-N", by position in ListenerConfig.Targets)
+// so BuildQueryRouter can match them back to their targets.
+func BuildTargetPools(cfg *ListenerConfig, dial func(TargetConfig) (net.Conn, pgproto.BackendKeyData, error), rawDial func(TargetConfig) (net.Conn, error)) (primary *TargetPool, replicas map[string]*TargetPool, err error) {
+	replicas = map[string]*TargetPool{}
+
+	for name, target := range namedTargets(cfg.Targets) {
+		target := target
+		pool := NewTargetPool(
+			target.TargetConfig,
+			func() (net.Conn, pgproto.BackendKeyData, error) { return dial(target.TargetConfig) },
+			func() (net.Conn, error) { return rawDial(target.TargetConfig) },
+		)
+
+		switch target.Role {
+		case TargetRolePrimary:
+			if primary != nil {
+				return nil, nil, fmt.Errorf("wiring: listener has more than one primary target")
+			}
+			primary = pool
+		case TargetRoleReplica:
+			replicas[name] = pool
+		default:
+			return nil, nil, fmt.Errorf("wiring: target %q has unknown role %q", name, target.Role)
+		}
+	}
+
+	if primary == nil {
+		return nil, nil, fmt.Errorf("wiring: listener has no primary target")
+	}
+
+	return primary, replicas, nil
+}
+
+// namedTargets pairs each of cfg.Targets with a stable name, since
+// ListenerConfig.Targets is a plain slice rather than a map. Targets are
+// named by their position until the config format grows an explicit name
+// field.
+func namedTargets(targets []TargetRoleConfig) map[string]TargetRoleConfig {
+	named := make(map[string]TargetRoleConfig, len(targets))
+	for i, target := range targets {
+		named[fmt.Sprintf("target-%d", i)] = target
+	}
+	return named
+}
+
+// BuildQueryRouter assembles a QueryRouter for a listener from its already-
+// built primary and replica pools, registers every replica with its own
+// administrative (unauthenticated) dial func, and starts the background
+// health checker if cfg.Routing.HealthCheckInterval is configured. stop
+// should be closed when the listener is torn down so the health-check
+// goroutine exits.
+func BuildQueryRouter(cfg *ListenerConfig, primary *TargetPool, replicas map[string]*TargetPool, rawDial func(TargetConfig) (net.Conn, error), stop <-chan struct{}) *QueryRouter {
+	var primaryTarget TargetConfig
+	for _, target := range cfg.Targets {
+		if target.Role == TargetRolePrimary {
+			primaryTarget = target.TargetConfig
+			break
+		}
+	}
+
+	router := NewQueryRouter(cfg.Routing, primary, func() (net.Conn, error) { return rawDial(primaryTarget) })
+
+	for name, target := range namedTargets(cfg.Targets) {
+		if target.Role != TargetRoleReplica {
+			continue
+		}
+		pool, ok := replicas[name]
+		if !ok {
+			continue
+		}
+		target := target
+		router.AddReplica(name, pool, func() (net.Conn, error) { return rawDial(target.TargetConfig) })
+	}
+
+	interval := time.Duration(cfg.Routing.HealthCheckInterval) * time.Second
+	if interval > 0 {
+		go router.RunHealthChecks(stop, interval)
+	}
+
+	return router
+}
+
+// WireSession installs a listener's already-built pooling, routing, query
+// interceptor and query-logging plugins onto a freshly constructed Session,
+// before its Handle is run. clientCancelKey is the BackendKeyData.ProcessID
+// the gateway issued to the client (see Session.SetPool). interceptors is
+// built by the caller from ListenerConfig.Interceptors (e.g. by a
+// PluginRegistry resolving each entry by name); a nil or empty slice leaves
+// the session's extended query protocol traffic untouched.
+func WireSession(s *Session, primary *TargetPool, router *QueryRouter, poolMode string, clientCancelKey int32, interceptors []QueryInterceptor, logger *ZapLoggingPlugin) {
+	if primary != nil {
+		s.SetPool(primary, poolMode, clientCancelKey)
+		if router != nil {
+			s.SetRouter(router)
+		}
+	}
+	s.SetInterceptors(interceptors)
+	if logger != nil {
+		s.SetQueryLogger(logger)
+	}
+}